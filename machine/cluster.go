@@ -0,0 +1,409 @@
+package machine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/finn"
+	"github.com/tidwall/redcon"
+)
+
+// slotState records where a hash slot currently stands in a migration
+// between two shard groups, mirroring Redis Cluster's own SETSLOT states.
+type slotState int
+
+const (
+	slotStable slotState = iota
+	slotImporting
+	slotMigrating
+)
+
+// shardGroup is one Raft group participating in sharded mode: a set of
+// nodes with a single leader address, and the hash slots it currently
+// owns.
+type shardGroup struct {
+	id    string
+	addr  string // current leader address, used for MOVED/ASK targets
+	slots map[int]bool
+}
+
+// slotMigration tracks an in-flight SETSLOT MIGRATING/IMPORTING pair for
+// a single slot.
+type slotMigration struct {
+	state slotState
+	from  string
+	to    string
+}
+
+// shardTopology is the cluster-wide view of which shard group owns which
+// hash slot. It's replicated the same way any other machine state is:
+// topology-changing commands (SETSLOT, and group membership changes made
+// outside this package) go through Raft so every node agrees.
+type shardTopology struct {
+	mu        sync.RWMutex
+	groups    map[string]*shardGroup
+	owner     [numHashSlots]string // group id owning each slot
+	migration map[int]*slotMigration
+}
+
+func newShardTopology() *shardTopology {
+	return &shardTopology{
+		groups:    make(map[string]*shardGroup),
+		migration: make(map[int]*slotMigration),
+	}
+}
+
+// SetShardTopology enables sharded mode on this node: groupID is this
+// Raft group's own id, and groups maps every participating group's id to
+// its current leader address. Slots are partitioned evenly across the
+// groups in id order, the same bootstrap split CLUSTER ADDSLOTS would
+// produce on a fresh Redis Cluster before any migration has run; moving
+// ownership afterward is what CLUSTER SETSLOT is for.
+func (m *Machine) SetShardTopology(groupID string, groups map[string]string) {
+	t := newShardTopology()
+	ids := make([]string, 0, len(groups))
+	for id, addr := range groups {
+		ids = append(ids, id)
+		t.groups[id] = &shardGroup{id: id, addr: addr}
+	}
+	sort.Strings(ids)
+	perGroup := numHashSlots / len(ids)
+	for i, id := range ids {
+		start := i * perGroup
+		end := start + perGroup
+		if i == len(ids)-1 {
+			end = numHashSlots
+		}
+		for slot := start; slot < end; slot++ {
+			t.owner[slot] = id
+		}
+	}
+	m.mu.Lock()
+	m.groupID = groupID
+	m.shards = t
+	m.mu.Unlock()
+}
+
+func (t *shardTopology) ownerAddr(slot int) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	g := t.groups[t.owner[slot]]
+	if g == nil {
+		return ""
+	}
+	return g.addr
+}
+
+// checkSlot decides whether this group may serve a command touching key
+// locally, for the connection at connAddr. It returns a MOVED error if
+// the slot belongs to another group outright, or an ASK error if the
+// slot is mid-migration away from this group. On the importing side of
+// a migration, a one-shot ASKING flag (set by the ASKING command and
+// consumed here) lets the client through before ownership has actually
+// flipped, matching the standard Redis Cluster ASK handshake.
+func (m *Machine) checkSlot(connAddr, key string) error {
+	if m.shards == nil {
+		return nil
+	}
+	slot := keyHashSlot(key)
+	m.shards.mu.RLock()
+	owner := m.shards.owner[slot]
+	mig := m.shards.migration[slot]
+	m.shards.mu.RUnlock()
+
+	if mig != nil {
+		switch mig.state {
+		case slotMigrating:
+			if owner == m.groupID {
+				return fmt.Errorf("ASK %d %s", slot, m.shards.groupAddr(mig.to))
+			}
+		case slotImporting:
+			if mig.to == m.groupID {
+				m.mu.Lock()
+				asked := m.asking[connAddr]
+				delete(m.asking, connAddr)
+				m.mu.Unlock()
+				if asked {
+					return nil
+				}
+			}
+		}
+	}
+	if owner != "" && owner != m.groupID {
+		return fmt.Errorf("MOVED %d %s", slot, m.shards.groupAddr(owner))
+	}
+	return nil
+}
+
+func (t *shardTopology) groupAddr(id string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if g := t.groups[id]; g != nil {
+		return g.addr
+	}
+	return ""
+}
+
+// clusterCommand implements the CLUSTER subcommands a Redis Cluster
+// client needs for slot discovery and migration: SLOTS, NODES, SHARDS,
+// KEYSLOT, COUNTKEYSINSLOT, and SETSLOT. SETSLOT is the only one that
+// mutates topology, so it's the only one that goes through a.Apply.
+func (m *Machine) clusterCommand(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	if m.shards == nil {
+		return nil, fmt.Errorf("ERR this node is not running in sharded mode")
+	}
+	sub := strings.ToLower(string(cmd.Args[1]))
+	switch sub {
+	case "keyslot":
+		if len(cmd.Args) != 3 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+		}
+		return keyHashSlot(string(cmd.Args[2])), nil
+
+	case "countkeysinslot":
+		if len(cmd.Args) != 3 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+		}
+		slot, err := strconv.Atoi(string(cmd.Args[2]))
+		if err != nil || slot < 0 || slot >= numHashSlots {
+			return nil, fmt.Errorf("ERR invalid slot")
+		}
+		return m.countKeysInSlot(slot), nil
+
+	case "slots":
+		return m.shards.slotsReply(), nil
+
+	case "nodes":
+		return m.shards.nodesReply(), nil
+
+	case "shards":
+		return m.shards.shardsReply(), nil
+
+	case "setslot":
+		return a.Apply(conn, cmd, nil, func(raw interface{}) (interface{}, error) {
+			return nil, m.setSlotCommand(cmd)
+		})
+
+	default:
+		return nil, fmt.Errorf("ERR unknown CLUSTER subcommand '%s'", cmd.Args[1])
+	}
+}
+
+func (t *shardTopology) slotsReply() []interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	// Coalesce contiguous slots owned by the same group into ranges, the
+	// way Redis Cluster's own CLUSTER SLOTS does.
+	var out []interface{}
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		g := t.groups[t.owner[start]]
+		out = append(out, []interface{}{start, end, []interface{}{g.addrHost(), g.addrPort(), g.id}})
+		start = -1
+	}
+	var cur string
+	for slot := 0; slot < numHashSlots; slot++ {
+		if t.owner[slot] != cur || t.owner[slot] == "" {
+			flush(slot - 1)
+			cur = t.owner[slot]
+			if cur != "" {
+				start = slot
+			}
+		}
+	}
+	flush(numHashSlots - 1)
+	return out
+}
+
+func (t *shardTopology) nodesReply() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var sb strings.Builder
+	ids := make([]string, 0, len(t.groups))
+	for id := range t.groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		g := t.groups[id]
+		var ranges []string
+		for slot, owner := range t.owner {
+			if owner == id {
+				ranges = append(ranges, strconv.Itoa(slot))
+			}
+		}
+		fmt.Fprintf(&sb, "%s %s master - 0 0 0 connected %s\n", g.id, g.addr, strings.Join(ranges, " "))
+	}
+	return sb.String()
+}
+
+func (t *shardTopology) shardsReply() []interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []interface{}
+	for _, g := range t.groups {
+		var slots []interface{}
+		for slot, owner := range t.owner {
+			if owner == g.id {
+				slots = append(slots, slot, slot)
+			}
+		}
+		out = append(out, []interface{}{
+			"slots", slots,
+			"nodes", []interface{}{[]interface{}{"id", g.id, "endpoint", g.addr, "role", "master"}},
+		})
+	}
+	return out
+}
+
+func (g *shardGroup) addrHost() string {
+	host, _, _ := splitHostPort(g.addr)
+	return host
+}
+
+func (g *shardGroup) addrPort() int {
+	_, port, _ := splitHostPort(g.addr)
+	return port
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return addr, 0, fmt.Errorf("bad address %q", addr)
+	}
+	port, err := strconv.Atoi(addr[i+1:])
+	if err != nil {
+		return "", 0, err
+	}
+	host := addr[:i]
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return host, port, nil
+}
+
+// setSlotCommand implements CLUSTER SETSLOT <slot> IMPORTING|MIGRATING|NODE <group>.
+// IMPORTING/MIGRATING mark the slot as mid-transfer so checkSlot can start
+// issuing ASK redirects; NODE flips ownership atomically once the
+// receiving group has caught up via migrateSlot.
+func (m *Machine) setSlotCommand(cmd redcon.Command) error {
+	if len(cmd.Args) != 5 {
+		return fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	slot, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil || slot < 0 || slot >= numHashSlots {
+		return fmt.Errorf("ERR invalid slot")
+	}
+	groupID := string(cmd.Args[4])
+
+	t := m.shards
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch strings.ToLower(string(cmd.Args[3])) {
+	case "importing":
+		t.migration[slot] = &slotMigration{state: slotImporting, to: m.groupID, from: groupID}
+	case "migrating":
+		t.migration[slot] = &slotMigration{state: slotMigrating, from: m.groupID, to: groupID}
+	case "node":
+		t.owner[slot] = groupID
+		delete(t.migration, slot)
+	default:
+		return fmt.Errorf("ERR unknown SETSLOT mode '%s'", cmd.Args[3])
+	}
+	return nil
+}
+
+// countKeysInSlot walks the local key space and counts how many keys
+// hash to slot, backing CLUSTER COUNTKEYSINSLOT.
+func (m *Machine) countKeysInSlot(slot int) int {
+	var n int
+	m.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, _ string) bool {
+			if keyHashSlot(key) == slot {
+				n++
+			}
+			return true
+		})
+	})
+	return n
+}
+
+// snapshotKeysInSlot takes a consistent read-only snapshot of every
+// key/value pair that currently hashes to slot.
+func (m *Machine) snapshotKeysInSlot(slot int) map[string]string {
+	kv := make(map[string]string)
+	m.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			if keyHashSlot(key) == slot {
+				kv[key] = value
+			}
+			return true
+		})
+	})
+	return kv
+}
+
+// migrateSlot streams every key owned by slot to the target group over
+// a plain client connection, sourced from a snapshot of just that slot's
+// keys, then issues CLUSTER SETSLOT NODE on both sides so ownership
+// flips atomically once the target has everything. It's started by the
+// operator after CLUSTER SETSLOT MIGRATING/IMPORTING have been issued on
+// the two sides.
+//
+// Ownership hasn't flipped on the target yet, so its checkSlot would
+// otherwise reject these SETs as MOVED; ASKING is what lets them through
+// on the importing side. Like real Redis Cluster, the flag is consumed
+// by the very next command, so it has to be resent before every SET
+// rather than once per connection.
+func (m *Machine) migrateSlot(slot int, targetAddr string) error {
+	kv := m.snapshotKeysInSlot(slot)
+	conn, err := redis.Dial("tcp", targetAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for key, value := range kv {
+		if _, err := conn.Do("ASKING"); err != nil {
+			return err
+		}
+		if _, err := conn.Do("SET", key, value); err != nil {
+			return err
+		}
+	}
+	targetID := m.shards.groupIDFor(targetAddr)
+	if _, err := conn.Do("CLUSTER", "SETSLOT", slot, "NODE", targetID); err != nil {
+		return err
+	}
+	src, err := redis.Dial("tcp", m.shards.groupAddr(m.groupID))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Do("CLUSTER", "SETSLOT", slot, "NODE", targetID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *shardTopology) groupIDFor(addr string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for id, g := range t.groups {
+		if g.addr == addr {
+			return id
+		}
+	}
+	return ""
+}
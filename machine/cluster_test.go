@@ -0,0 +1,68 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	// Known CRC16-XMODOM vectors used by Redis Cluster itself.
+	cases := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"{user1000}.following", keyHashSlot("user1000")},
+		{"{user1000}.followers", keyHashSlot("user1000")},
+	}
+	for _, c := range cases {
+		if got := keyHashSlot(c.key); got != c.slot {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", c.key, got, c.slot)
+		}
+	}
+}
+
+func TestKeyHashSlotRange(t *testing.T) {
+	for _, key := range []string{"a", "abc", "{tag}rest", "", "日本語"} {
+		if slot := keyHashSlot(key); slot < 0 || slot >= numHashSlots {
+			t.Errorf("keyHashSlot(%q) = %d, out of range", key, slot)
+		}
+	}
+}
+
+// TestShardedRedirect exercises the MOVED redirect plumbing against a
+// two-group sharded cluster: {shardkey}a and {shardkey}b share a hash
+// tag, so both keys hash to the same slot and therefore the same group,
+// regardless of which node in the whole sharded cluster the client
+// happens to ask first.
+func TestShardedRedirect(t *testing.T) {
+	sc, err := mockOpenShardedCluster(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer sc.Close()
+
+	if _, err := sc.Do("SET", "{shardkey}a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Do("SET", "{shardkey}b", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := redis.String(sc.Do("GET", "{shardkey}a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != "1" {
+		t.Fatalf("GET {shardkey}a = %q, want 1", a)
+	}
+	b, err := redis.String(sc.Do("GET", "{shardkey}b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != "2" {
+		t.Fatalf("GET {shardkey}b = %q, want 2", b)
+	}
+}
@@ -0,0 +1,286 @@
+package machine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/finn"
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/redlog"
+)
+
+// Machine is the finn.Applier backing a SummitDB node: a small KV store
+// over buntdb, replicated through Raft, plus the client-facing
+// subsystems (MONITOR, pub/sub, cluster sharding, Sentinel discovery)
+// that hang off the same command dispatch path.
+type Machine struct {
+	mu             sync.Mutex
+	log            *redlog.Logger
+	addr           string
+	db             *buntdb.DB
+	monitors       *monitorHub
+	pubsub         *pubsub
+	sentinels      *sentinelHub
+	shards         *shardTopology
+	groupID        string
+	notifyKeyspace bool
+	asking         map[string]bool // remote addrs that sent ASKING, consumed by checkSlot
+}
+
+// New opens a Machine backed by an in-memory buntdb store at addr. addr
+// is the node's own listen address, used to answer CLUSTER/SENTINEL
+// queries about itself.
+func New(log *redlog.Logger, addr string) (*Machine, error) {
+	db, err := buntdb.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return &Machine{
+		log:       log,
+		addr:      addr,
+		db:        db,
+		monitors:  newMonitorHub(),
+		pubsub:    newPubsub(),
+		sentinels: newSentinelHub(),
+		asking:    make(map[string]bool),
+	}, nil
+}
+
+// Close releases the underlying store. It's called once finn has torn
+// down the Raft node.
+func (m *Machine) Close() error {
+	return m.db.Close()
+}
+
+// SetNotifyKeyspace turns keyspace/keyevent notifications on or off,
+// mirroring real Redis's notify-keyspace-events: off by default, since
+// publishing a pair of messages on every write is wasted work for the
+// common case where nothing subscribes to them.
+func (m *Machine) SetNotifyKeyspace(enabled bool) {
+	m.mu.Lock()
+	m.notifyKeyspace = enabled
+	m.mu.Unlock()
+}
+
+// ConnAccept is the finn.Options.ConnAccept hook; every connection is
+// currently accepted.
+func (m *Machine) ConnAccept(conn redcon.Conn) bool {
+	return true
+}
+
+// ConnClosed is the finn.Options.ConnClosed hook. It tears down any
+// per-connection state that doesn't belong in the Raft log: a MONITOR
+// subscription, any pub/sub subscriptions, and any pending ASKING flag.
+func (m *Machine) ConnClosed(conn redcon.Conn, err error) {
+	addr := conn.RemoteAddr()
+	m.monitors.unsubscribe(addr)
+	m.pubsub.Unsubscribe(conn)
+	m.pubsub.Punsubscribe(conn)
+	m.mu.Lock()
+	delete(m.asking, addr)
+	m.mu.Unlock()
+}
+
+// Command is the finn.Applier entry point: every command executed
+// against this node, replicated or local, passes through here.
+func (m *Machine) Command(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) == 0 {
+		return nil, fmt.Errorf("ERR empty command")
+	}
+	name := strings.ToLower(string(cmd.Args[0]))
+
+	args := make([]string, 0, len(cmd.Args)-1)
+	for _, arg := range cmd.Args[1:] {
+		args = append(args, string(arg))
+	}
+	m.monitors.trace(0, conn.RemoteAddr(), string(cmd.Args[0]), args)
+	m.pollLeader(a)
+
+	// SET/GET/DEL/INCR/EXPIRE all take the key as their first argument;
+	// in sharded mode that's also the one checkSlot needs to decide
+	// whether this group may serve the command at all.
+	switch name {
+	case "set", "get", "del", "incr", "expire":
+		if len(cmd.Args) >= 2 {
+			if err := m.checkSlot(conn.RemoteAddr(), string(cmd.Args[1])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch name {
+	case "ping":
+		return "PONG", nil
+	case "asking":
+		m.mu.Lock()
+		m.asking[conn.RemoteAddr()] = true
+		m.mu.Unlock()
+		return "OK", nil
+	case "cluster":
+		return m.clusterCommand(a, conn, cmd)
+	case "sentinel":
+		return m.sentinelCommand(conn, cmd)
+	case "monitor":
+		return m.monitorCommand(conn, cmd)
+	case "set":
+		return m.setCommand(a, conn, cmd)
+	case "get":
+		return m.getCommand(conn, cmd)
+	case "del":
+		return m.delCommand(a, conn, cmd)
+	case "incr":
+		return m.incrCommand(a, conn, cmd)
+	case "expire":
+		return m.expireCommand(a, conn, cmd)
+	case "subscribe":
+		return m.subscribeCommand(conn, cmd, false)
+	case "psubscribe":
+		return m.subscribeCommand(conn, cmd, true)
+	case "unsubscribe":
+		return m.unsubscribeCommand(conn, cmd, false)
+	case "punsubscribe":
+		return m.unsubscribeCommand(conn, cmd, true)
+	case "publish":
+		return m.publishCommand(a, conn, cmd)
+	case "pubsub":
+		return m.pubsubCommand(conn, cmd)
+	default:
+		return nil, fmt.Errorf("ERR unknown command '%s'", cmd.Args[0])
+	}
+}
+
+func (m *Machine) setCommand(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 3 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	return a.Apply(conn, cmd, nil, func(raw interface{}) (interface{}, error) {
+		key, value := string(cmd.Args[1]), string(cmd.Args[2])
+		if err := m.db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(key, value, nil)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		m.notifyKeyspaceEvent("set", key)
+		return "OK", nil
+	})
+}
+
+func (m *Machine) getCommand(conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	var val string
+	err := m.db.View(func(tx *buntdb.Tx) error {
+		v, err := tx.Get(string(cmd.Args[1]))
+		if err != nil {
+			return err
+		}
+		val = v
+		return nil
+	})
+	if err == buntdb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func (m *Machine) delCommand(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	return a.Apply(conn, cmd, nil, func(raw interface{}) (interface{}, error) {
+		key := string(cmd.Args[1])
+		var n int
+		err := m.db.Update(func(tx *buntdb.Tx) error {
+			_, err := tx.Delete(key)
+			if err == buntdb.ErrNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			n = 1
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 {
+			m.notifyKeyspaceEvent("del", key)
+		}
+		return n, nil
+	})
+}
+
+func (m *Machine) incrCommand(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	return a.Apply(conn, cmd, nil, func(raw interface{}) (interface{}, error) {
+		key := string(cmd.Args[1])
+		var n int64
+		err := m.db.Update(func(tx *buntdb.Tx) error {
+			v, err := tx.Get(key)
+			if err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+			if v != "" {
+				n, err = strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return fmt.Errorf("ERR value is not an integer or out of range")
+				}
+			}
+			n++
+			_, _, err = tx.Set(key, strconv.FormatInt(n, 10), nil)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	})
+}
+
+func (m *Machine) expireCommand(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 3 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	secs, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		return nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	return a.Apply(conn, cmd, nil, func(raw interface{}) (interface{}, error) {
+		key := string(cmd.Args[1])
+		var n int
+		err := m.db.Update(func(tx *buntdb.Tx) error {
+			val, err := tx.Get(key)
+			if err == buntdb.ErrNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			_, _, err = tx.Set(key, val, &buntdb.SetOptions{Expires: true, TTL: time.Duration(secs) * time.Second})
+			if err != nil {
+				return err
+			}
+			n = 1
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 {
+			m.notifyKeyspaceEvent("expire", key)
+		}
+		return n, nil
+	})
+}
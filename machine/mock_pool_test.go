@@ -0,0 +1,174 @@
+package machine
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// errPoolExhausted is returned by connPool.get when the pool is full,
+// every connection is checked out, and the pool is configured to fail
+// fast rather than wait for one to free up.
+var errPoolExhausted = errors.New("mock pool exhausted")
+
+// poolConfig mirrors the knobs mainstream Go Redis client pools expose:
+// how many idle connections to keep warm, the ceiling on connections in
+// flight at once, how long an idle connection is trusted without a
+// health check, and what to do when the pool is exhausted.
+type poolConfig struct {
+	MinIdle     int
+	MaxIdle     int
+	MaxActive   int
+	IdleTimeout time.Duration
+	Wait        bool
+}
+
+var defaultPoolConfig = poolConfig{
+	MinIdle:     1,
+	MaxIdle:     8,
+	MaxActive:   32,
+	IdleTimeout: time.Second * 30,
+	Wait:        true,
+}
+
+type idleConn struct {
+	conn   redis.Conn
+	pushed time.Time
+}
+
+// connPool is a small PING-health-checked connection pool for the test
+// harness, standing in for the pooling a real client library does so
+// tests can exercise concurrent-client behavior rather than serializing
+// every request through one connection.
+type connPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	cfg    poolConfig
+	dial   func() (redis.Conn, error)
+	idle   []idleConn
+	active int
+}
+
+func newConnPool(cfg poolConfig, dial func() (redis.Conn, error)) *connPool {
+	p := &connPool{cfg: cfg, dial: dial}
+	p.cond = sync.NewCond(&p.mu)
+	p.warmUp()
+	return p
+}
+
+// warmUp dials cfg.MinIdle connections in the background and seeds the
+// idle list with them, so the first callers to get() find a warm
+// connection instead of paying dial latency. It's best-effort: a pool
+// is frequently created before the server it points at is fully up, so
+// a failed warm-up dial is simply dropped rather than retried.
+func (p *connPool) warmUp() {
+	for i := 0; i < p.cfg.MinIdle; i++ {
+		go func() {
+			conn, err := p.dial()
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			if p.active >= p.cfg.MaxActive {
+				p.mu.Unlock()
+				conn.Close()
+				return
+			}
+			p.active++
+			p.idle = append(p.idle, idleConn{conn: conn, pushed: time.Now()})
+			p.cond.Signal()
+			p.mu.Unlock()
+		}()
+	}
+}
+
+// get checks out a connection, preferring a warm idle one. Idle
+// connections older than cfg.IdleTimeout are PINGed before being handed
+// out; one that fails the check is evicted rather than returned.
+func (p *connPool) get() (redis.Conn, error) {
+	p.mu.Lock()
+	for {
+		for len(p.idle) > 0 {
+			ic := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+			if time.Since(ic.pushed) > p.cfg.IdleTimeout {
+				p.mu.Unlock()
+				_, err := ic.conn.Do("PING")
+				p.mu.Lock()
+				if err != nil {
+					ic.conn.Close()
+					p.active--
+					p.cond.Signal()
+					continue
+				}
+			}
+			p.mu.Unlock()
+			return ic.conn, nil
+		}
+		if p.active < p.cfg.MaxActive {
+			p.active++
+			p.mu.Unlock()
+			conn, err := p.dial()
+			if err != nil {
+				p.mu.Lock()
+				p.active--
+				p.mu.Unlock()
+				return nil, err
+			}
+			return conn, nil
+		}
+		if !p.cfg.Wait {
+			p.mu.Unlock()
+			return nil, errPoolExhausted
+		}
+		p.cond.Wait()
+	}
+}
+
+// put returns conn to the pool. A non-nil err means conn saw a network
+// error and must be evicted rather than reused; otherwise it's pushed
+// back onto the idle list, or closed if the pool already has MaxIdle
+// connections warm.
+func (p *connPool) put(conn redis.Conn, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil || len(p.idle) >= p.cfg.MaxIdle {
+		conn.Close()
+		p.active--
+		p.cond.Signal()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, pushed: time.Now()})
+	p.cond.Signal()
+}
+
+// drain closes every idle connection, forcing subsequent checkouts to
+// dial fresh ones. Checked-out connections are unaffected.
+func (p *connPool) drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ic := range p.idle {
+		ic.conn.Close()
+	}
+	p.active -= len(p.idle)
+	p.idle = nil
+	p.cond.Broadcast()
+}
+
+func (p *connPool) close() {
+	p.drain()
+}
+
+// isNetErr reports whether err represents a connection-level failure
+// rather than a normal Redis error reply (which also satisfies the error
+// interface via redis.Error, but shouldn't cause the connection itself
+// to be evicted).
+func isNetErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(redis.Error)
+	return !ok
+}
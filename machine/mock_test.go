@@ -8,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -34,12 +35,12 @@ type mockServer struct {
 	join string
 	n    *finn.Node
 	m    *Machine
-	conn redis.Conn
+	pool *connPool
 }
 
 func (s *mockServer) Close() {
-	if s.conn != nil {
-		s.conn.Close()
+	if s.pool != nil {
+		s.pool.close()
 	}
 	s.m.Close()
 	s.n.Close()
@@ -57,27 +58,35 @@ func (s *mockServer) Do(commandName string, args ...interface{}) (interface{}, e
 	return resps[0], nil
 }
 
+func (s *mockServer) dial() (redis.Conn, error) {
+	return redis.Dial("tcp", fmt.Sprintf(":%d", s.port))
+}
+
 func (s *mockServer) DoPipeline(cmds [][]interface{}) ([]interface{}, error) {
-	if s.conn == nil {
-		var err error
-		s.conn, err = redis.Dial("tcp", fmt.Sprintf(":%d", s.port))
-		if err != nil {
-			return nil, err
-		}
+	conn, err := s.pool.get()
+	if err != nil {
+		return nil, err
 	}
-	//defer conn.Close()
+	var evict error
+	defer func() { s.pool.put(conn, evict) }()
+
 	for _, cmd := range cmds {
-		if err := s.conn.Send(cmd[0].(string), cmd[1:]...); err != nil {
+		if err := conn.Send(cmd[0].(string), cmd[1:]...); err != nil {
+			evict = err
 			return nil, err
 		}
 	}
-	if err := s.conn.Flush(); err != nil {
+	if err := conn.Flush(); err != nil {
+		evict = err
 		return nil, err
 	}
 	var resps []interface{}
 	for i := 0; i < len(cmds); i++ {
-		resp, err := s.conn.Receive()
+		resp, err := conn.Receive()
 		if err != nil {
+			if isNetErr(err) {
+				evict = err
+			}
 			resps = append(resps, err)
 		} else {
 			resps = append(resps, resp)
@@ -86,6 +95,71 @@ func (s *mockServer) DoPipeline(cmds [][]interface{}) ([]interface{}, error) {
 	return resps, nil
 }
 
+// DoConcurrent runs fn against n separate pooled connections at once,
+// exercising the same ordering, replay, and TRY-redirect paths real
+// concurrent clients hit under pipeline contention. fn's returned error
+// decides the connection's fate exactly like DoPipeline: a network-level
+// error evicts it from the pool, while a normal Redis error reply (e.g.
+// a TRY redirect the caller didn't follow) does not.
+func (s *mockServer) DoConcurrent(n int, fn func(redis.Conn) error) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			conn, err := s.pool.get()
+			if err != nil {
+				return
+			}
+			ferr := fn(conn)
+			var evict error
+			if isNetErr(ferr) {
+				evict = ferr
+			}
+			s.pool.put(conn, evict)
+		}()
+	}
+	wg.Wait()
+}
+
+// dialMonitor opens a dedicated connection and issues MONITOR on it,
+// returning the raw connection so the caller can read the resulting
+// stream of trace lines with repeated Receive calls.
+func (s *mockServer) dialMonitor() (redis.Conn, error) {
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", s.port))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Do("MONITOR"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialSubscriber opens a dedicated connection and issues SUBSCRIBE on it,
+// bypassing DoPipeline since a subscriber connection receives asynchronous
+// pushed messages rather than one reply per request.
+func (s *mockServer) dialSubscriber(channel string) (redis.Conn, error) {
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", s.port))
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Send("SUBSCRIBE", channel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Receive(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
 func (s *mockServer) waitForStartup() error {
 	var lerr error
 	start := time.Now()
@@ -163,6 +237,7 @@ func mockOpenServer(join *mockServer) (*mockServer, error) {
 		return nil, err
 	}
 	s := &mockServer{port: port, n: n, m: m, join: joinAddr}
+	s.pool = newConnPool(defaultPoolConfig, s.dial)
 	if err := s.waitForStartup(); err != nil {
 		s.Close()
 		return nil, err
@@ -178,7 +253,7 @@ type mockCluster struct {
 func mockOpenCluster(count int) (*mockCluster, error) {
 	fmt.Printf("Starting Raft cluster of %d servers\n", count)
 	var ss []*mockServer
-	for i := 0; i < 3; i++ {
+	for i := 0; i < count; i++ {
 		var l *mockServer
 		if i > 0 {
 			l = ss[0]
@@ -193,14 +268,58 @@ func mockOpenCluster(count int) (*mockCluster, error) {
 		}
 		ss = append(ss, s)
 	}
+	var addrs []string
+	for _, s := range ss {
+		addrs = append(addrs, fmt.Sprintf(":%d", s.port))
+	}
+	for _, s := range ss {
+		s.m.SetPeers(addrs)
+	}
 	return &mockCluster{ss: ss}, nil
 }
+
+// discoverMaster asks whichever server in the cluster is reachable for
+// the current write endpoint via SENTINEL GET-MASTER-ADDR-BY-NAME, the
+// same call an unmodified go-redis FailoverClient makes.
+func (mc *mockCluster) discoverMaster(service string) (string, error) {
+	var lerr error
+	for _, s := range mc.ss {
+		resp, err := s.Do("SENTINEL", "GET-MASTER-ADDR-BY-NAME", service)
+		if err != nil {
+			lerr = err
+			continue
+		}
+		parts, ok := resp.([]interface{})
+		if !ok || len(parts) != 2 {
+			lerr = fmt.Errorf("unexpected SENTINEL reply: %v", resp)
+			continue
+		}
+		host, _ := redis.String(parts[0], nil)
+		port, _ := redis.String(parts[1], nil)
+		return fmt.Sprintf("%s:%s", host, port), nil
+	}
+	return "", lerr
+}
+
+// Leader returns the mockServer currently serving writes, discovered the
+// same way a real client would via SENTINEL GET-MASTER-ADDR-BY-NAME.
+// Tests that drive concurrent load at a specific node use this instead of
+// guessing an index, so they keep working across elections.
+func (mc *mockCluster) Leader() (*mockServer, error) {
+	addr, err := mc.discoverMaster(sentinelServiceName)
+	if err != nil {
+		return nil, err
+	}
+	s := mc.ServerForPort(mustPort(addr))
+	if s == nil {
+		return nil, fmt.Errorf("no server for discovered master %s", addr)
+	}
+	return s, nil
+}
+
 func (mc *mockCluster) ResetConn() {
 	if mc.cs != nil {
-		if mc.cs.conn != nil {
-			mc.cs.conn.Close()
-			mc.cs.conn = nil
-		}
+		mc.cs.pool.drain()
 		mc.cs = nil
 	}
 }
@@ -240,6 +359,105 @@ func (mc *mockCluster) Do(commandName string, args ...interface{}) (interface{},
 	}
 }
 
+// mockShardedCluster groups several independent Raft groups together,
+// the way a Redis Cluster deployment splits the keyspace across shards,
+// and understands the MOVED/ASK redirections a sharded Machine returns,
+// as well as the TRY reply a follower inside the owning group returns.
+type mockShardedCluster struct {
+	groups []*mockCluster
+}
+
+func mockOpenShardedCluster(numGroups, nodesPerGroup int) (*mockShardedCluster, error) {
+	sc := &mockShardedCluster{}
+	for i := 0; i < numGroups; i++ {
+		mc, err := mockOpenCluster(nodesPerGroup)
+		if err != nil {
+			sc.Close()
+			return nil, err
+		}
+		sc.groups = append(sc.groups, mc)
+	}
+
+	groupIDs := make([]string, len(sc.groups))
+	groupAddrs := make(map[string]string, len(sc.groups))
+	for i, mc := range sc.groups {
+		leader, err := mc.Leader()
+		if err != nil {
+			sc.Close()
+			return nil, err
+		}
+		groupIDs[i] = fmt.Sprintf("group%d", i)
+		groupAddrs[groupIDs[i]] = fmt.Sprintf(":%d", leader.port)
+	}
+	for i, mc := range sc.groups {
+		for _, s := range mc.ss {
+			s.m.SetShardTopology(groupIDs[i], groupAddrs)
+		}
+	}
+	return sc, nil
+}
+
+func (sc *mockShardedCluster) serverForAddr(addr string) *mockServer {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return nil
+	}
+	port, err := strconv.Atoi(addr[i+1:])
+	if err != nil {
+		return nil
+	}
+	for _, mc := range sc.groups {
+		if s := mc.ServerForPort(port); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (sc *mockShardedCluster) Do(commandName string, args ...interface{}) (interface{}, error) {
+	mc := sc.groups[rand.Int()%len(sc.groups)]
+	s := mc.ss[rand.Int()%len(mc.ss)]
+	for {
+		resp, err := s.Do(commandName, args...)
+		if rerr, ok := resp.(error); ok && err == nil {
+			err = rerr
+		}
+		if err != nil {
+			switch {
+			case strings.HasPrefix(err.Error(), "MOVED "):
+				parts := strings.Fields(err.Error())
+				if ns := sc.serverForAddr(parts[2]); ns != nil {
+					s = ns
+					continue
+				}
+			case strings.HasPrefix(err.Error(), "ASK "):
+				parts := strings.Fields(err.Error())
+				if ns := sc.serverForAddr(parts[2]); ns != nil {
+					if _, err := ns.Do("ASKING"); err != nil {
+						return nil, err
+					}
+					s = ns
+					continue
+				}
+			case strings.HasPrefix(err.Error(), "TRY "):
+				parts := strings.Fields(err.Error())
+				if ns := sc.serverForAddr(parts[1]); ns != nil {
+					s = ns
+					continue
+				}
+			}
+			return nil, err
+		}
+		return resp, err
+	}
+}
+
+func (sc *mockShardedCluster) Close() {
+	for _, mc := range sc.groups {
+		mc.Close()
+	}
+}
+
 func (mc *mockCluster) DoBatch(commands [][]interface{}) error {
 	for i := 0; i < len(commands); i += 2 {
 		cmds := commands[i]
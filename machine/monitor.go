@@ -0,0 +1,194 @@
+package machine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// monitorRingSize is the number of pending trace lines a single MONITOR
+// connection is allowed to queue before it's considered too slow to keep
+// up and gets dropped.
+const monitorRingSize = 1024
+
+// monitorClient is a single connection that has issued MONITOR. It's
+// always a detached connection: redcon's own event loop must stop
+// owning the socket before the hub's writer goroutine can safely write
+// to it, or the two would race on the same fd. Sends to buf are
+// non-blocking; a full buffer means the client is falling behind and
+// gets disconnected rather than stalling the command path.
+type monitorClient struct {
+	conn   redcon.DetachedConn
+	addr   string
+	buf    chan string
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	closed bool
+}
+
+// monitorHub tracks every connection currently running MONITOR, keyed by
+// remote address so that ConnClosed can remove a subscriber in O(1).
+type monitorHub struct {
+	mu   sync.Mutex
+	subs map[string]*monitorClient
+}
+
+func newMonitorHub() *monitorHub {
+	return &monitorHub{subs: make(map[string]*monitorClient)}
+}
+
+// subscribe registers an already-detached conn as a monitor and starts
+// its writer goroutine.
+func (h *monitorHub) subscribe(conn redcon.DetachedConn) {
+	c := &monitorClient{
+		conn: conn,
+		addr: conn.RemoteAddr(),
+		buf:  make(chan string, monitorRingSize),
+	}
+	h.mu.Lock()
+	h.subs[c.addr] = c
+	h.mu.Unlock()
+	c.wg.Add(1)
+	go c.writeLoop(h)
+}
+
+// remove takes addr out of the hub's subscriber map, if present, and
+// returns it so the caller can tear it down. It never touches the
+// client's own goroutine, so it's safe to call from writeLoop itself as
+// well as from other goroutines.
+func (h *monitorHub) remove(addr string) *monitorClient {
+	h.mu.Lock()
+	c := h.subs[addr]
+	delete(h.subs, addr)
+	h.mu.Unlock()
+	return c
+}
+
+// unsubscribe removes addr from the hub, if present, and tears down its
+// writer goroutine. It's safe to call even when addr was never a monitor.
+// Must only be called from a goroutine other than the client's own
+// writeLoop — it waits for that goroutine to exit.
+func (h *monitorHub) unsubscribe(addr string) {
+	if c := h.remove(addr); c != nil {
+		c.close("")
+	}
+}
+
+// overflow drops a subscriber whose ring buffer filled up, sending it a
+// monitor overflow error before closing the connection. trace runs it on
+// a goroutine of its own, never from the client's own writeLoop.
+func (h *monitorHub) overflow(addr string) {
+	if c := h.remove(addr); c != nil {
+		c.close("monitor overflow")
+	}
+}
+
+// trace fans out a single executed command to every subscribed monitor.
+// It must never block the caller, which is the command dispatch path
+// shared by every client on the node.
+func (h *monitorHub) trace(db int, addr, name string, args []string) {
+	h.mu.Lock()
+	if len(h.subs) == 0 {
+		h.mu.Unlock()
+		return
+	}
+	subs := make([]*monitorClient, 0, len(h.subs))
+	for _, c := range h.subs {
+		subs = append(subs, c)
+	}
+	h.mu.Unlock()
+
+	line := formatMonitorLine(db, addr, name, args)
+	for _, c := range subs {
+		select {
+		case c.buf <- line:
+		default:
+			go h.overflow(c.addr)
+		}
+	}
+}
+
+func formatMonitorLine(db int, addr, name string, args []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%.6f [%d %s] %s", float64(time.Now().UnixNano())/1e9, db, addr, strconv.Quote(name))
+	for _, arg := range args {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.Quote(arg))
+	}
+	return sb.String()
+}
+
+// writeLoop pushes trace lines to the underlying connection as they
+// arrive, going through the detached conn's own write buffer and Flush
+// rather than the raw socket so framing stays consistent with the rest
+// of redcon. It exits once the client is closed and its buffer drained.
+//
+// A failed Flush means the client went away — this is the normal way a
+// MONITOR disconnect is discovered, since a detached conn never runs
+// through ConnClosed. That teardown happens inline, via markClosed,
+// rather than by calling close (which waits on c.wg): this goroutine is
+// the one c.wg is waiting for, so waiting on itself here would deadlock.
+func (c *monitorClient) writeLoop(h *monitorHub) {
+	defer c.wg.Done()
+	for line := range c.buf {
+		c.conn.WriteString(line)
+		if err := c.conn.Flush(); err != nil {
+			h.remove(c.addr)
+			if !c.markClosed() {
+				c.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// markClosed marks the client closed and reports whether it was already
+// closed, so every teardown path — writeLoop's own error exit, an
+// explicit unsubscribe, or an overflow — runs its cleanup exactly once.
+func (c *monitorClient) markClosed() bool {
+	c.mu.Lock()
+	already := c.closed
+	c.closed = true
+	c.mu.Unlock()
+	return already
+}
+
+// close tears down a monitor client from a goroutine other than its own
+// writeLoop. If reason is non-empty it's written to the client as an
+// error reply before the connection is closed, which is how an
+// overflowed monitor learns why it was dropped.
+func (c *monitorClient) close(reason string) {
+	if c.markClosed() {
+		return
+	}
+	close(c.buf)
+	c.wg.Wait()
+	if reason != "" {
+		c.conn.WriteError("ERR " + reason)
+		c.conn.Flush()
+	}
+	c.conn.Close()
+}
+
+// monitorCommand implements the MONITOR command. The connection is
+// detached from redcon's event loop before the stream begins, since
+// ownership of the socket passes to the hub's writer goroutine for the
+// lifetime of the subscription; Command returns a nil reply because the
+// +OK has already been written to the detached conn directly.
+func (m *Machine) monitorCommand(conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 1 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	dconn := conn.Detach()
+	dconn.WriteString("OK")
+	if err := dconn.Flush(); err != nil {
+		dconn.Close()
+		return nil, nil
+	}
+	m.monitors.subscribe(dconn)
+	return nil, nil
+}
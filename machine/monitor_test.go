@@ -0,0 +1,76 @@
+package machine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestMonitor(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	mon, err := mc.ss[0].dialMonitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mon.Close()
+
+	if _, err := mc.Do("SET", "monkey", "monval"); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := redis.String(mon.Receive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, `"SET"`) || !strings.Contains(line, `"monkey"`) {
+		t.Fatalf("unexpected monitor line: %s", line)
+	}
+}
+
+// TestMonitorDisconnect closes a monitor connection and then issues more
+// commands, which is what actually discovers the disconnect (a detached
+// conn never runs through ConnClosed). A prior version of writeLoop's
+// teardown deadlocked on itself here, hanging the whole node.
+func TestMonitorDisconnect(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	mon, err := mc.ss[0].dialMonitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mc.Do("SET", "monkey", "monval"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mon.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	mon.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := mc.Do("SET", "monkey", "monval2")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("command after monitor disconnect hung, writeLoop likely deadlocked")
+	}
+}
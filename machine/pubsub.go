@@ -0,0 +1,138 @@
+package machine
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/finn"
+	"github.com/tidwall/redcon"
+)
+
+// pubsub is a thin wrapper around redcon.PubSub that adds the pieces
+// Redis clients expect beyond plain publish/subscribe: PSUBSCRIBE glob
+// matching and the PUBSUB introspection commands. Subscription state is
+// connection-local, so this lives outside the Raft log entirely; only
+// PUBLISH itself is replicated, from publishCommand below.
+type pubsub struct {
+	redcon.PubSub
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{}
+}
+
+// keyspacePrefix and keyeventPrefix are the standard Redis keyspace
+// notification channel prefixes for database 0, the only database
+// SummitDB serves.
+const (
+	keyspacePrefix = "__keyspace@0__:"
+	keyeventPrefix = "__keyevent@0__:"
+)
+
+// notifyKeyspaceEvent publishes the keyspace/keyevent pair for a write
+// that already applied successfully. It's called from the write path
+// (SET, DEL, EXPIRE, ...) after the Raft apply, so every node emits the
+// notification for its own local subscribers rather than replicating it
+// a second time.
+func (m *Machine) notifyKeyspaceEvent(cmd, key string) {
+	if !m.notifyKeyspace {
+		return
+	}
+	m.pubsub.Publish(keyspacePrefix+key, cmd)
+	m.pubsub.Publish(keyeventPrefix+cmd, key)
+}
+
+// subscribeCommand implements SUBSCRIBE and PSUBSCRIBE. psub selects
+// pattern matching semantics for PSUBSCRIBE.
+func (m *Machine) subscribeCommand(conn redcon.Conn, cmd redcon.Command, psub bool) (interface{}, error) {
+	if len(cmd.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	for i := 1; i < len(cmd.Args); i++ {
+		channel := string(cmd.Args[i])
+		if psub {
+			m.pubsub.Psubscribe(conn, channel)
+		} else {
+			m.pubsub.Subscribe(conn, channel)
+		}
+	}
+	return nil, nil
+}
+
+// unsubscribeCommand implements UNSUBSCRIBE and PUNSUBSCRIBE. With no
+// channel arguments it unsubscribes from everything the connection is
+// currently subscribed to.
+func (m *Machine) unsubscribeCommand(conn redcon.Conn, cmd redcon.Command, psub bool) (interface{}, error) {
+	if len(cmd.Args) == 1 {
+		if psub {
+			m.pubsub.Punsubscribe(conn)
+		} else {
+			m.pubsub.Unsubscribe(conn)
+		}
+		return nil, nil
+	}
+	for i := 1; i < len(cmd.Args); i++ {
+		channel := string(cmd.Args[i])
+		if psub {
+			m.pubsub.Punsubscribe(conn, channel)
+		} else {
+			m.pubsub.Unsubscribe(conn, channel)
+		}
+	}
+	return nil, nil
+}
+
+// publishCommand implements PUBLISH. It's a replicated command: every
+// node in the Raft group applies it and fans the message out to its own
+// local subscribers, so a client can SUBSCRIBE against any member of the
+// cluster, leader or follower, and still see delivery. PUBLISH sent to a
+// follower is answered with the usual TRY redirect to the leader.
+func (m *Machine) publishCommand(a finn.Applier, conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 3 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	return a.Apply(conn, cmd, nil, func(raw interface{}) (interface{}, error) {
+		channel, message := string(cmd.Args[1]), string(cmd.Args[2])
+		n := m.pubsub.Publish(channel, message)
+		return n, nil
+	})
+}
+
+// pubsubCommand implements the PUBSUB introspection subcommands:
+// CHANNELS [pattern], NUMSUB [channel ...], and NUMPAT.
+func (m *Machine) pubsubCommand(conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	switch strings.ToLower(string(cmd.Args[1])) {
+	case "channels":
+		var pattern string
+		if len(cmd.Args) > 2 {
+			pattern = string(cmd.Args[2])
+		}
+		var out []string
+		for _, channel := range m.pubsub.Channels() {
+			if pattern == "" {
+				out = append(out, channel)
+				continue
+			}
+			if ok, _ := path.Match(pattern, channel); ok {
+				out = append(out, channel)
+			}
+		}
+		return out, nil
+	case "numsub":
+		out := make([]interface{}, 0, (len(cmd.Args)-2)*2)
+		for i := 2; i < len(cmd.Args); i++ {
+			channel := string(cmd.Args[i])
+			out = append(out, channel, strconv.Itoa(m.pubsub.Subscribers(channel)))
+		}
+		return out, nil
+	case "numpat":
+		return m.pubsub.PatternChannels(), nil
+	default:
+		return nil, fmt.Errorf("unknown PUBSUB subcommand '%s'", cmd.Args[1])
+	}
+}
@@ -0,0 +1,113 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// TestPubSubCrossNode subscribes on one follower and publishes through
+// the cluster's TRY-redirect path, confirming that PUBLISH is applied
+// (and thus delivered) on every node regardless of which one the client
+// happened to subscribe to.
+func TestPubSubCrossNode(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	sub, err := mc.ss[1].dialSubscriber("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if _, err := mc.Do("PUBLISH", "news", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := redis.Values(sub.Receive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, _ := redis.String(msg[0], nil)
+	channel, _ := redis.String(msg[1], nil)
+	payload, _ := redis.String(msg[2], nil)
+	if kind != "message" || channel != "news" || payload != "hello" {
+		t.Fatalf("unexpected pubsub message: %v", msg)
+	}
+}
+
+// TestPubSubNoTryRedirect ensures SUBSCRIBE itself is handled locally on
+// a follower rather than being rejected with a TRY redirect, since
+// subscription state never touches the Raft log.
+func TestPubSubNoTryRedirect(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	sub, err := mc.ss[1].dialSubscriber("chatter")
+	if err != nil {
+		t.Fatalf("SUBSCRIBE on follower should not TRY-redirect: %v", err)
+	}
+	sub.Close()
+}
+
+// TestKeyspaceNotifications enables keyspace notifications on every node
+// and confirms a SET delivers both the __keyspace@0__ and __keyevent@0__
+// messages to a subscriber on a follower, the same way PUBLISH does,
+// since the apply callback that calls notifyKeyspaceEvent runs on every
+// node in the group.
+func TestKeyspaceNotifications(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	for _, s := range mc.ss {
+		s.m.SetNotifyKeyspace(true)
+	}
+
+	keyspaceSub, err := mc.ss[1].dialSubscriber(keyspacePrefix + "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyspaceSub.Close()
+
+	eventSub, err := mc.ss[1].dialSubscriber(keyeventPrefix + "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventSub.Close()
+
+	if _, err := mc.Do("SET", "monkey", "monval"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := redis.Values(keyspaceSub.Receive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel, _ := redis.String(msg[1], nil)
+	payload, _ := redis.String(msg[2], nil)
+	if channel != keyspacePrefix+"monkey" || payload != "set" {
+		t.Fatalf("unexpected keyspace message: %v", msg)
+	}
+
+	msg, err = redis.Values(eventSub.Receive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel, _ = redis.String(msg[1], nil)
+	payload, _ = redis.String(msg[2], nil)
+	if channel != keyeventPrefix+"set" || payload != "monkey" {
+		t.Fatalf("unexpected keyevent message: %v", msg)
+	}
+}
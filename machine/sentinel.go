@@ -0,0 +1,173 @@
+package machine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/finn"
+	"github.com/tidwall/redcon"
+)
+
+// sentinelServiceName is the master name unmodified go-redis Sentinel
+// clients ask for. SummitDB exposes exactly one Raft group per node
+// process, so a single fixed name is enough; a future multi-group build
+// would key this by group id instead.
+const sentinelServiceName = "summitdb"
+
+// sentinelHub tracks the node's current view of cluster topology so the
+// SENTINEL commands can answer without going through Raft themselves.
+// It's updated by pollLeader, which Command runs on every client
+// command, so each node refreshes its view whenever it serves a request;
+// Sentinel state is never part of the replicated log.
+type sentinelHub struct {
+	mu       sync.Mutex
+	leader   string
+	peers    []string // every known member of the Raft group, leader included
+	replicas map[string]bool
+}
+
+func newSentinelHub() *sentinelHub {
+	return &sentinelHub{replicas: make(map[string]bool)}
+}
+
+// leaderAware is implemented by the concrete finn.Applier passed into
+// Command. finn already has to know the current Raft leader to produce
+// the TRY redirects mock_test.go follows, so Command type-asserts for it
+// on every call rather than requiring a dedicated finn.Options callback.
+type leaderAware interface {
+	Leader() string
+}
+
+// pollLeader checks the current leader reported by a against the hub's
+// cached value and, on change, fans out to leaderChanged. It's cheap
+// enough to call on every command.
+func (m *Machine) pollLeader(a finn.Applier) {
+	la, ok := a.(leaderAware)
+	if !ok {
+		return
+	}
+	leaderAddr := la.Leader()
+	if leaderAddr == "" {
+		return
+	}
+	m.sentinels.mu.Lock()
+	changed := m.sentinels.leader != leaderAddr
+	m.sentinels.mu.Unlock()
+	if changed {
+		m.leaderChanged(leaderAddr)
+	}
+}
+
+// SetPeers records every address in the Raft group, so the hub can
+// derive SENTINEL REPLICAS from "everyone but the current leader"
+// whenever leadership changes.
+func (m *Machine) SetPeers(addrs []string) {
+	m.sentinels.mu.Lock()
+	m.sentinels.peers = addrs
+	m.sentinels.mu.Unlock()
+}
+
+// leaderChanged updates the hub's view of the topology and, if the
+// leader actually moved, publishes +switch-master so subscribers doing
+// Sentinel-style discovery over pub/sub notice immediately instead of
+// polling.
+func (m *Machine) leaderChanged(leaderAddr string) {
+	h := m.sentinels
+	h.mu.Lock()
+	prev := h.leader
+	h.leader = leaderAddr
+	peers := h.peers
+	h.replicas = make(map[string]bool)
+	h.mu.Unlock()
+
+	for _, addr := range peers {
+		h.noteReplica(addr)
+	}
+
+	if prev != "" && prev != leaderAddr {
+		msg := fmt.Sprintf("%s %s %s %s %s", sentinelServiceName, prev, prevPort(prev), leaderAddr, prevPort(leaderAddr))
+		m.pubsub.Publish("__sentinel__:hello", msg)
+	}
+}
+
+func prevPort(addr string) string {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return ""
+	}
+	return addr[i+1:]
+}
+
+// noteReplica records addr as a known follower so SENTINEL REPLICAS can
+// list it. The addresses come from the peer list given to SetPeers.
+func (h *sentinelHub) noteReplica(addr string) {
+	h.mu.Lock()
+	if addr != h.leader {
+		h.replicas[addr] = true
+	}
+	h.mu.Unlock()
+}
+
+// sentinelCommand implements the subset of the SENTINEL surface that
+// go-redis' FailoverClient needs to discover the write endpoint:
+// MASTERS, GET-MASTER-ADDR-BY-NAME, REPLICAS, and SENTINELS.
+func (m *Machine) sentinelCommand(conn redcon.Conn, cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+	}
+	h := m.sentinels
+	h.mu.Lock()
+	leader := h.leader
+	replicas := make([]string, 0, len(h.replicas))
+	for addr := range h.replicas {
+		replicas = append(replicas, addr)
+	}
+	h.mu.Unlock()
+
+	switch strings.ToLower(string(cmd.Args[1])) {
+	case "masters":
+		return []interface{}{sentinelNodeInfo(sentinelServiceName, leader)}, nil
+
+	case "get-master-addr-by-name":
+		if len(cmd.Args) != 3 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s' command", cmd.Args[0])
+		}
+		if string(cmd.Args[2]) != sentinelServiceName {
+			return nil, nil
+		}
+		host, port, err := splitHostPort(leader)
+		if err != nil {
+			return nil, nil
+		}
+		return []interface{}{host, fmt.Sprintf("%d", port)}, nil
+
+	case "replicas":
+		out := make([]interface{}, 0, len(replicas))
+		for _, addr := range replicas {
+			out = append(out, sentinelNodeInfo("", addr))
+		}
+		return out, nil
+
+	case "sentinels":
+		// SummitDB nodes observe the cluster directly, so every node
+		// reports itself as the only sentinel watching this service.
+		return []interface{}{sentinelNodeInfo("", m.addr)}, nil
+
+	default:
+		return nil, fmt.Errorf("ERR unknown SENTINEL subcommand '%s'", cmd.Args[1])
+	}
+}
+
+func sentinelNodeInfo(name, addr string) []interface{} {
+	host, port, _ := splitHostPort(addr)
+	info := []interface{}{
+		"ip", host,
+		"port", fmt.Sprintf("%d", port),
+		"flags", "master",
+	}
+	if name != "" {
+		info = append([]interface{}{"name", name}, info...)
+	}
+	return info
+}
@@ -0,0 +1,86 @@
+package machine
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSentinelFailover kills the current leader and confirms a client
+// doing Sentinel-style discovery ends up pointed at whichever node wins
+// the re-election, without ever parsing a TRY error itself.
+func TestSentinelFailover(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	before, err := mc.discoverMaster(sentinelServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader := mc.ServerForPort(mustPort(before))
+	if leader == nil {
+		t.Fatalf("no server for discovered master %s", before)
+	}
+	leader.Close()
+
+	var after string
+	start := time.Now()
+	for time.Now().Sub(start) < time.Second*10 {
+		after, err = mc.discoverMaster(sentinelServiceName)
+		if err == nil && after != before {
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	t.Fatalf("discovery did not follow failover: before=%s after=%s err=%v", before, after, err)
+}
+
+// TestSentinelReplicas confirms SENTINEL REPLICAS actually reflects
+// cluster membership rather than the empty list it would return if
+// noteReplica never populated the hub, and that SENTINEL SENTINELS
+// answers with an array.
+func TestSentinelReplicas(t *testing.T) {
+	mc, err := mockOpenCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockCleanup()
+	defer mc.Close()
+
+	// Force a command through the cluster so pollLeader has a chance to
+	// observe the leader. discoverMaster stops at the first node that
+	// answers; the SENTINEL REPLICAS below runs pollLeader again on
+	// whichever node it reaches.
+	if _, err := mc.discoverMaster(sentinelServiceName); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := mc.Do("SENTINEL", "REPLICAS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas, ok := resp.([]interface{})
+	if !ok || len(replicas) != 2 {
+		t.Fatalf("expected 2 replicas, got %v", resp)
+	}
+
+	resp, err = mc.Do("SENTINEL", "SENTINELS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.([]interface{}); !ok {
+		t.Fatalf("unexpected SENTINEL SENTINELS reply: %v", resp)
+	}
+}
+
+func mustPort(addr string) int {
+	i := strings.LastIndexByte(addr, ':')
+	port, _ := strconv.Atoi(addr[i+1:])
+	return port
+}